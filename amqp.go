@@ -0,0 +1,730 @@
+// Package messaging contains the data structures and functions used to
+// publish and consume messages on the DE's AMQP broker.
+package messaging
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cyverse-de/model/v8"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Routing keys used throughout the DE for job control and notification
+// messages.
+const (
+	// UpdatesKey is the routing key used for job status update messages.
+	UpdatesKey = "jobs.updates"
+
+	// TimeLimitRequestsKey is the base routing key used when a service wants
+	// a job's remaining time limit.
+	TimeLimitRequestsKey = "timelimitrequests"
+
+	// TimeLimitResponseKey is the base routing key used when a job responds
+	// with its remaining time limit.
+	TimeLimitResponseKey = "timelimitresponses"
+
+	// TimeLimitDeltaKey is the base routing key used when a job's time limit
+	// should be adjusted by a delta.
+	TimeLimitDeltaKey = "timelimitdeltas"
+
+	// StopsKey is the base routing key used when a job should be stopped.
+	StopsKey = "stops"
+
+	// EmailRequestPublishingKey is the routing key used for outgoing email
+	// requests.
+	EmailRequestPublishingKey = "email_request"
+)
+
+// TimeLimitRequestKey returns the routing key used to request the time
+// limit for the job with the given invocation ID.
+func TimeLimitRequestKey(invID string) string {
+	return fmt.Sprintf("%s.%s", TimeLimitRequestsKey, invID)
+}
+
+// TimeLimitRequestQueueName returns the name of the queue that a job with
+// the given invocation ID should listen on for time limit requests.
+func TimeLimitRequestQueueName(invID string) string {
+	return fmt.Sprintf("road-runner-%s-tl-request", invID)
+}
+
+// TimeLimitResponsesKey returns the routing key used to publish the time
+// limit remaining for the job with the given invocation ID.
+func TimeLimitResponsesKey(invID string) string {
+	return fmt.Sprintf("%s.%s", TimeLimitResponseKey, invID)
+}
+
+// TimeLimitResponsesQueueName returns the name of the queue that should be
+// used to receive the time limit remaining for the job with the given
+// invocation ID.
+func TimeLimitResponsesQueueName(invID string) string {
+	return fmt.Sprintf("road-runner-%s-tl-response", invID)
+}
+
+// TimeLimitDeltaRequestKey returns the routing key used to adjust the time
+// limit for the job with the given invocation ID.
+func TimeLimitDeltaRequestKey(invID string) string {
+	return fmt.Sprintf("%s.%s", TimeLimitDeltaKey, invID)
+}
+
+// TimeLimitDeltaQueueName returns the name of the queue that a job with the
+// given invocation ID should listen on for time limit delta requests.
+func TimeLimitDeltaQueueName(invID string) string {
+	return fmt.Sprintf("road-runner-%s-tl-delta", invID)
+}
+
+// StopRequestKey returns the routing key used to request that the job with
+// the given invocation ID be stopped.
+func StopRequestKey(invID string) string {
+	return fmt.Sprintf("%s.%s", StopsKey, invID)
+}
+
+// StopQueueName returns the name of the queue that a job with the given
+// invocation ID should listen on for stop requests.
+func StopQueueName(invID string) string {
+	return fmt.Sprintf("road-runner-%s-stops-request", invID)
+}
+
+// JobCommand tells the receiver of a JobRequest what it should do with the
+// job contained in the request.
+type JobCommand int
+
+const (
+	// Launch indicates that a job should be launched.
+	Launch JobCommand = iota
+
+	// Stop indicates that a job should be stopped.
+	Stop
+)
+
+// StatusCode represents the status of a time limit or job control response.
+type StatusCode int
+
+const (
+	// Success indicates that an operation completed successfully.
+	Success StatusCode = iota
+)
+
+// JobState is the state that a running job can be in.
+type JobState string
+
+const (
+	// RunningState means the job is currently running.
+	RunningState JobState = "Running"
+
+	// SucceededState means the job completed successfully.
+	SucceededState JobState = "Completed"
+
+	// FailedState means the job failed to complete.
+	FailedState JobState = "Failed"
+)
+
+// JobRequest is the format for a message sent to the jobs request queue.
+type JobRequest struct {
+	Version  int
+	Job      *model.Job
+	Command  JobCommand
+	Priority uint8
+}
+
+// NewLaunchRequest returns a pointer to a new JobRequest instance
+// configured to launch the given job.
+func NewLaunchRequest(job *model.Job) *JobRequest {
+	return &JobRequest{
+		Version: 0,
+		Job:     job,
+		Command: Launch,
+	}
+}
+
+// StopRequest contains the information needed to stop a running job.
+type StopRequest struct {
+	Version      int
+	InvocationID string
+	Username     string
+	Reason       string
+	Priority     uint8
+}
+
+// NewStopRequest returns a pointer to a new StopRequest instance.
+func NewStopRequest() *StopRequest {
+	return &StopRequest{
+		Version: 0,
+	}
+}
+
+// TimeLimitRequest is sent to a running job to request its remaining time
+// limit.
+type TimeLimitRequest struct {
+	InvocationID string
+}
+
+// TimeLimitResponse is sent by a running job in response to a
+// TimeLimitRequest.
+type TimeLimitResponse struct {
+	InvocationID          string
+	Status                StatusCode
+	MillisecondsRemaining int64
+}
+
+// TimeLimitDelta is sent to a running job to adjust its time limit by the
+// given delta.
+type TimeLimitDelta struct {
+	InvocationID string
+	Delta        string
+	Priority     uint8
+}
+
+// UpdateMessage contains a status update for a running job.
+type UpdateMessage struct {
+	Job     *model.Job
+	Version int
+	State   JobState
+	Message string
+	Sender  string
+	SentOn  int64
+}
+
+// EmailRequest contains the information needed to send an email on behalf
+// of the DE.
+type EmailRequest struct {
+	TemplateName        string
+	TemplateValues      map[string]interface{}
+	Subject             string
+	ToAddress           string
+	CourtesyCopyAddress string
+	FromAddress         string
+	FromName            string
+}
+
+// NotificationMessage is the body of a notification sent to a user.
+type NotificationMessage struct {
+	Deleted       bool
+	Email         bool
+	EmailTemplate string
+	Message       map[string]interface{}
+	Payload       map[string]interface{}
+	Seen          bool
+	Subject       string
+	Type          string
+	User          string
+}
+
+// WrappedNotificationMessage wraps a NotificationMessage along with the
+// total number of notifications the recipient currently has.
+type WrappedNotificationMessage struct {
+	Total   int
+	Message *NotificationMessage
+}
+
+// MessageHandler defines the signature for a function that handles incoming
+// deliveries for a registered consumer.
+type MessageHandler func(context.Context, amqp.Delivery)
+
+// consumerRegistration records the information needed to (re)bind a
+// consumer to the broker.
+type consumerRegistration struct {
+	exchange     string
+	exchangeType string
+	queue        string
+	key          string
+	handler      MessageHandler
+	prefetch     int
+	maxPriority  uint8
+}
+
+// Client wraps an AMQP connection and channel and provides convenience
+// methods for publishing and consuming the messages used across the DE.
+type Client struct {
+	uri                string
+	reconnect          bool
+	disconnectBehavior DisconnectBehavior
+	delayedBackend     DelayedMessageBackend
+	dedupStore         DedupStore
+	dedupTTL           time.Duration
+
+	mu             sync.RWMutex
+	connection     *amqp.Connection
+	publishingChan *amqp.Channel
+	exchange       string
+	exchangeType   string
+	consumers      []consumerRegistration
+	state          ConnectionState
+	closedCh       chan struct{}
+	closeOnce      sync.Once
+	reconnectSubs  []chan struct{}
+}
+
+// NewClient returns a pointer to a new Client instance after dialing the
+// broker at the given URI. If reconnect is true, the connection, publisher
+// channel, and every consumer registered with AddConsumer are automatically
+// restored with a backoff if the connection closes unexpectedly. opts can
+// be used to configure optional behavior, such as the backend used for
+// scheduled publishing or how Publish behaves while disconnected.
+func NewClient(uri string, reconnect bool, opts ...ClientOption) (*Client, error) {
+	connection, err := amqp.Dial(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &Client{
+		uri:        uri,
+		reconnect:  reconnect,
+		connection: connection,
+		state:      Connected,
+		closedCh:   make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(client)
+	}
+
+	go client.superviseConnection()
+
+	return client, nil
+}
+
+// Close shuts down the client's connection to the broker for good. The
+// connection is not automatically restored, even if the client was created
+// with reconnect set to true.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	c.state = Closed
+	conn := c.connection
+	c.mu.Unlock()
+
+	c.markClosed()
+
+	return conn.Close()
+}
+
+// conn returns the client's current connection.
+func (c *Client) conn() *amqp.Connection {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.connection
+}
+
+// channel returns the client's current publishing channel.
+func (c *Client) channel() *amqp.Channel {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.publishingChan
+}
+
+// SetupPublishing opens a channel that will be used for all subsequent
+// calls to Publish and declares the exchange that messages will be
+// published to. If the client was configured with
+// WithDelayedMessageBackend(DelayedMessagePlugin), the exchange is declared
+// as an x-delayed-message exchange so that PublishAt and PublishIn can
+// delay delivery using the plugin instead of the TTL/dead-letter fallback.
+func (c *Client) SetupPublishing(exchange string) error {
+	channel, err := c.conn().Channel()
+	if err != nil {
+		return err
+	}
+
+	exchangeType := "topic"
+	var args amqp.Table
+	if c.delayedBackend == DelayedMessagePlugin {
+		args = amqp.Table{"x-delayed-type": exchangeType}
+		exchangeType = "x-delayed-message"
+	}
+
+	if err = channel.ExchangeDeclare(
+		exchange,
+		exchangeType,
+		true,
+		false,
+		false,
+		false,
+		args,
+	); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.exchange = exchange
+	c.exchangeType = exchangeType
+	c.publishingChan = channel
+	c.mu.Unlock()
+
+	go c.watchPublishingChannel(channel)
+
+	return nil
+}
+
+// Publish sends body to the configured exchange using key as the routing
+// key. If the client is disconnected from the broker, Publish either blocks
+// until the connection is restored or returns ErrDisconnected immediately,
+// depending on the DisconnectBehavior the client was configured with.
+func (c *Client) Publish(key string, body []byte) error {
+	return c.publish(key, body, 0)
+}
+
+// PublishWithPriority is the same as Publish, except that priority is set on
+// the published message so that a priority queue bound with
+// CreatePriorityQueue or AddPriorityConsumer can deliver it ahead of
+// lower-priority messages already queued. priority is ignored by queues that
+// weren't declared with x-max-priority.
+func (c *Client) PublishWithPriority(key string, body []byte, priority uint8) error {
+	return c.publish(key, body, priority)
+}
+
+// publish sends body to the configured exchange using key as the routing
+// key and priority as the message priority.
+func (c *Client) publish(key string, body []byte, priority uint8) error {
+	return c.publishContext(context.Background(), key, body, priority)
+}
+
+// publishContext is the same as publish, except that ctx governs the
+// write, as with PublishContext.
+func (c *Client) publishContext(ctx context.Context, key string, body []byte, priority uint8) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := c.awaitConnectedContext(ctx); err != nil {
+		return err
+	}
+
+	c.mu.RLock()
+	exchange := c.exchange
+	c.mu.RUnlock()
+
+	publishing := amqp.Publishing{
+		DeliveryMode: amqp.Persistent,
+		Timestamp:    time.Now(),
+		ContentType:  "application/json",
+		Priority:     priority,
+		Body:         body,
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		publishing.Headers = amqp.Table{deadlineHeader: deadline.UnixNano()}
+
+		expiration := time.Until(deadline)
+		if expiration < 0 {
+			expiration = 0
+		}
+		publishing.Expiration = strconv.FormatInt(expiration.Milliseconds(), 10)
+	}
+
+	// amqp091-go's PublishWithContext doesn't actually select on ctx.Done()
+	// around the underlying socket write, so once we get this far, ctx can
+	// no longer abort an in-flight publish; the ctx.Err() check above is
+	// what makes an already-cancelled or already-expired ctx take effect.
+	return c.channel().PublishWithContext(ctx, exchange, key, false, false, publishing)
+}
+
+// publishJSON marshals v to JSON and publishes it under key.
+func (c *Client) publishJSON(key string, v interface{}) error {
+	return c.publishJSONContext(context.Background(), key, v)
+}
+
+// publishJSONContext is the same as publishJSON, except that ctx governs
+// the publish, as with PublishContext.
+func (c *Client) publishJSONContext(ctx context.Context, key string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.publishContext(ctx, key, body, 0)
+}
+
+// SendTimeLimitRequest requests the remaining time limit for the job with
+// the given invocation ID. If the client was configured with WithDedup, a
+// request with the same invocation ID issued again within the configured
+// window is suppressed and ErrDuplicate is returned.
+func (c *Client) SendTimeLimitRequest(invID string) error {
+	return c.publishJSONDeduped(TimeLimitRequestKey(invID), &TimeLimitRequest{
+		InvocationID: invID,
+	})
+}
+
+// SendTimeLimitRequestContext is the same as SendTimeLimitRequest, except
+// that ctx governs the publish, as with PublishContext.
+func (c *Client) SendTimeLimitRequestContext(ctx context.Context, invID string) error {
+	return c.publishJSONDedupedContext(ctx, TimeLimitRequestKey(invID), &TimeLimitRequest{
+		InvocationID: invID,
+	}, 0)
+}
+
+// SendTimeLimitResponse publishes the remaining time limit, in
+// milliseconds, for the job with the given invocation ID.
+func (c *Client) SendTimeLimitResponse(invID string, millisecondsRemaining int64) error {
+	return c.publishJSON(TimeLimitResponsesKey(invID), &TimeLimitResponse{
+		InvocationID:          invID,
+		Status:                Success,
+		MillisecondsRemaining: millisecondsRemaining,
+	})
+}
+
+// SendTimeLimitDelta requests that the time limit for the job with the
+// given invocation ID be adjusted by delta. If the client was configured
+// with WithDedup, the same delta for the same invocation ID issued again
+// within the configured window is suppressed and ErrDuplicate is returned.
+func (c *Client) SendTimeLimitDelta(invID, delta string) error {
+	return c.publishJSONDeduped(TimeLimitDeltaRequestKey(invID), &TimeLimitDelta{
+		InvocationID: invID,
+		Delta:        delta,
+	})
+}
+
+// SendTimeLimitDeltaContext is the same as SendTimeLimitDelta, except that
+// ctx governs the publish, as with PublishContext.
+func (c *Client) SendTimeLimitDeltaContext(ctx context.Context, invID, delta string) error {
+	return c.publishJSONDedupedContext(ctx, TimeLimitDeltaRequestKey(invID), &TimeLimitDelta{
+		InvocationID: invID,
+		Delta:        delta,
+	}, 0)
+}
+
+// SendTimeLimitDeltaWithPriority is the same as SendTimeLimitDelta, except
+// that the request is published with priority instead of the default
+// priority of 0, so that an urgent delta can preempt a backlog of routine
+// job updates on a priority queue created with CreatePriorityQueue or
+// AddPriorityConsumer.
+func (c *Client) SendTimeLimitDeltaWithPriority(invID, delta string, priority uint8) error {
+	return c.publishJSONDedupedWithPriority(TimeLimitDeltaRequestKey(invID), &TimeLimitDelta{
+		InvocationID: invID,
+		Delta:        delta,
+		Priority:     priority,
+	}, priority)
+}
+
+// SendTimeLimitDeltaWithPriorityContext is the same as
+// SendTimeLimitDeltaWithPriority, except that ctx governs the publish, as
+// with PublishContext.
+func (c *Client) SendTimeLimitDeltaWithPriorityContext(ctx context.Context, invID, delta string, priority uint8) error {
+	return c.publishJSONDedupedContext(ctx, TimeLimitDeltaRequestKey(invID), &TimeLimitDelta{
+		InvocationID: invID,
+		Delta:        delta,
+		Priority:     priority,
+	}, priority)
+}
+
+// SendStopRequest requests that the job with the given invocation ID be
+// stopped. The request is published with MaxPriority, so that on a priority
+// queue created with CreatePriorityQueue or AddPriorityConsumer, a
+// user-initiated cancel is always processed ahead of a backlog of routine
+// job updates. If the client was configured with WithDedup, the same stop
+// request issued again within the configured window is suppressed and
+// ErrDuplicate is returned.
+func (c *Client) SendStopRequest(invID, username, reason string) error {
+	return c.publishJSONDedupedWithPriority(StopRequestKey(invID), &StopRequest{
+		Version:      0,
+		InvocationID: invID,
+		Username:     username,
+		Reason:       reason,
+		Priority:     MaxPriority,
+	}, MaxPriority)
+}
+
+// SendStopRequestContext is the same as SendStopRequest, except that ctx
+// governs the publish, as with PublishContext.
+func (c *Client) SendStopRequestContext(ctx context.Context, invID, username, reason string) error {
+	return c.publishJSONDedupedContext(ctx, StopRequestKey(invID), &StopRequest{
+		Version:      0,
+		InvocationID: invID,
+		Username:     username,
+		Reason:       reason,
+		Priority:     MaxPriority,
+	}, MaxPriority)
+}
+
+// PublishJobUpdate publishes a status update for a running job. SentOn is
+// set to the current time as a side-effect.
+func (c *Client) PublishJobUpdate(update *UpdateMessage) error {
+	update.SentOn = time.Now().UnixNano() / int64(time.Millisecond)
+	return c.publishJSON(UpdatesKey, update)
+}
+
+// PublishJobUpdateContext is the same as PublishJobUpdate, except that ctx
+// governs the publish, as with PublishContext.
+func (c *Client) PublishJobUpdateContext(ctx context.Context, update *UpdateMessage) error {
+	update.SentOn = time.Now().UnixNano() / int64(time.Millisecond)
+	return c.publishJSONContext(ctx, UpdatesKey, update)
+}
+
+// PublishEmailRequest publishes a request to send an email on behalf of the
+// DE.
+func (c *Client) PublishEmailRequest(request *EmailRequest) error {
+	return c.publishJSON(EmailRequestPublishingKey, request)
+}
+
+// PublishEmailRequestContext is the same as PublishEmailRequest, except
+// that ctx governs the publish, as with PublishContext.
+func (c *Client) PublishEmailRequestContext(ctx context.Context, request *EmailRequest) error {
+	return c.publishJSONContext(ctx, EmailRequestPublishingKey, request)
+}
+
+// PublishNotificationMessage publishes a notification for delivery to a
+// user.
+func (c *Client) PublishNotificationMessage(message *WrappedNotificationMessage) error {
+	key := fmt.Sprintf("notification.%s", message.Message.User)
+	return c.publishJSON(key, message)
+}
+
+// PublishNotificationMessageContext is the same as
+// PublishNotificationMessage, except that ctx governs the publish, as with
+// PublishContext.
+func (c *Client) PublishNotificationMessageContext(ctx context.Context, message *WrappedNotificationMessage) error {
+	key := fmt.Sprintf("notification.%s", message.Message.User)
+	return c.publishJSONContext(ctx, key, message)
+}
+
+// AddConsumer declares the exchange, queue, and binding given, then starts
+// consuming deliveries on queue and dispatches each one to handler. The
+// registration is also kept around so that it can be rebuilt if the client
+// has to reconnect.
+func (c *Client) AddConsumer(exchange, exchangeType, queue, key string, handler MessageHandler, prefetch int) {
+	reg := consumerRegistration{
+		exchange:     exchange,
+		exchangeType: exchangeType,
+		queue:        queue,
+		key:          key,
+		handler:      handler,
+		prefetch:     prefetch,
+	}
+
+	c.mu.Lock()
+	c.consumers = append(c.consumers, reg)
+	c.mu.Unlock()
+
+	if err := c.startConsumer(reg); err != nil {
+		fmt.Fprintf(os.Stderr, "messaging: failed to start consumer for queue %s: %s\n", queue, err)
+	}
+}
+
+// Listen blocks until the client's connection to the broker is closed for
+// good, i.e. Close was called or the connection dropped while the client
+// was created with reconnect set to false. It does not return while the
+// client is reconnecting.
+func (c *Client) Listen() error {
+	<-c.closedCh
+	return nil
+}
+
+// startConsumer declares the exchange, queue, and binding for reg and
+// launches a goroutine that dispatches deliveries to reg.handler.
+func (c *Client) startConsumer(reg consumerRegistration) error {
+	channel, err := c.conn().Channel()
+	if err != nil {
+		return err
+	}
+
+	// If reg.exchange is the one SetupPublishing declared as an
+	// x-delayed-message exchange, it has to be redeclared the same way.
+	exchangeType := reg.exchangeType
+	var exchangeArgs amqp.Table
+	c.mu.RLock()
+	if c.delayedBackend == DelayedMessagePlugin && reg.exchange == c.exchange {
+		exchangeType = c.exchangeType
+		exchangeArgs = amqp.Table{"x-delayed-type": reg.exchangeType}
+	}
+	c.mu.RUnlock()
+
+	if err = channel.ExchangeDeclare(
+		reg.exchange,
+		exchangeType,
+		true,
+		false,
+		false,
+		false,
+		exchangeArgs,
+	); err != nil {
+		return err
+	}
+
+	var args amqp.Table
+	if reg.maxPriority > 0 {
+		args = priorityArgs(reg.maxPriority)
+	}
+
+	if _, err = channel.QueueDeclare(reg.queue, true, false, false, false, args); err != nil {
+		if reg.maxPriority > 0 && isPreconditionFailed(err) {
+			return fmt.Errorf("%w: %s", ErrQueueExistsWithoutPriority, reg.queue)
+		}
+		return err
+	}
+
+	if err = channel.QueueBind(reg.queue, reg.key, reg.exchange, false, nil); err != nil {
+		return err
+	}
+
+	if reg.prefetch > 0 {
+		if err = channel.Qos(reg.prefetch, 0, false); err != nil {
+			return err
+		}
+	}
+
+	deliveries, err := channel.Consume(reg.queue, "", false, false, false, false, nil)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for d := range deliveries {
+			ctx, cancel := deliveryContext(d)
+			reg.handler(ctx, d)
+			cancel()
+		}
+	}()
+
+	return nil
+}
+
+// CreateQueue declares a queue with the given name, durability, and
+// auto-delete settings, binds it to exchange using key as the routing key,
+// and returns the channel it was declared on. The caller is responsible for
+// closing the returned channel.
+func (c *Client) CreateQueue(name, exchange, key string, durable, autoDelete bool) (*amqp.Channel, error) {
+	channel, err := c.conn().Channel()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = channel.QueueDeclare(name, durable, autoDelete, false, false, nil); err != nil {
+		return nil, err
+	}
+
+	if err = channel.QueueBind(name, key, exchange, false, nil); err != nil {
+		return nil, err
+	}
+
+	return channel, nil
+}
+
+// QueueExists returns true if a queue with the given name, durability, and
+// auto-delete settings already exists on the broker.
+func (c *Client) QueueExists(name string, durable, autoDelete bool) (bool, error) {
+	channel, err := c.conn().Channel()
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = channel.Close() }()
+
+	if _, err = channel.QueueDeclarePassive(name, durable, autoDelete, false, false, nil); err != nil {
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// DeleteQueue deletes the queue with the given name.
+func (c *Client) DeleteQueue(name string) error {
+	channel, err := c.conn().Channel()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = channel.Close() }()
+
+	_, err = channel.QueueDelete(name, false, false, false)
+	return err
+}
@@ -0,0 +1,58 @@
+package messaging
+
+import (
+	"context"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// deadlineHeader is the AMQP header used to carry a publish's context
+// deadline through to the consumer side, so a MessageHandler can derive a
+// context with the same deadline instead of always getting
+// context.Background().
+const deadlineHeader = "x-deadline-unix-nano"
+
+// PublishContext is the same as Publish, except that ctx governs the
+// publish: if ctx is already cancelled or past its deadline, the publish is
+// rejected with ctx.Err() before anything is written, and if ctx has a
+// deadline, it's attached to the message as a deadlineHeader header and an
+// Expiration (in milliseconds), so the broker can drop the message if it's
+// not delivered before the deadline passes. amqp091-go's underlying socket
+// write isn't itself interruptible, so ctx can't abort a publish that's
+// already in flight — only the no-longer-worth-sending check up front, and
+// the eventual expiration on the broker side, are affected by ctx.
+func (c *Client) PublishContext(ctx context.Context, key string, body []byte) error {
+	return c.publishContext(ctx, key, body, 0)
+}
+
+// deliveryContext returns a context for dispatching d to a MessageHandler.
+// If d carries a deadlineHeader header, as set by PublishContext and its
+// context-taking variants, the returned context carries the same deadline;
+// otherwise it's context.Background(). The returned cancel func must be
+// called once the handler returns, to release resources associated with
+// the deadline.
+func deliveryContext(d amqp.Delivery) (context.Context, context.CancelFunc) {
+	if d.Headers != nil {
+		if nanos, ok := deadlineUnixNano(d.Headers[deadlineHeader]); ok {
+			return context.WithDeadline(context.Background(), time.Unix(0, nanos))
+		}
+	}
+	return context.Background(), func() {}
+}
+
+// deadlineUnixNano normalizes the numeric types amqp091-go may decode a
+// table value as, since the exact integer type a broker round-trips a
+// header value through isn't guaranteed.
+func deadlineUnixNano(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return n, true
+	case int32:
+		return int64(n), true
+	case int:
+		return int64(n), true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,102 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestDeliveryContextWithDeadlineHeader(t *testing.T) {
+	deadline := time.Now().Add(time.Minute)
+	d := amqp.Delivery{Headers: amqp.Table{deadlineHeader: deadline.UnixNano()}}
+
+	ctx, cancel := deliveryContext(d)
+	defer cancel()
+
+	actual, ok := ctx.Deadline()
+	if !ok {
+		t.Fatal("deliveryContext did not return a context with a deadline")
+	}
+	if !actual.Equal(deadline) {
+		t.Errorf("deliveryContext returned deadline %v instead of %v", actual, deadline)
+	}
+}
+
+func TestDeliveryContextWithoutDeadlineHeader(t *testing.T) {
+	ctx, cancel := deliveryContext(amqp.Delivery{})
+	defer cancel()
+
+	if _, ok := ctx.Deadline(); ok {
+		t.Error("deliveryContext returned a context with a deadline for a delivery with no deadline header")
+	}
+}
+
+func TestPublishContextPropagatesDeadlineToConsumer(t *testing.T) {
+	if !shouldrun() {
+		return
+	}
+
+	client := GetClient(t)
+
+	queue := "test_deadline_queue"
+	key := "tests.deadline"
+	defer func() { _ = client.DeleteQueue(queue) }()
+
+	deadline := time.Now().Add(time.Minute)
+	coord := make(chan time.Time, 1)
+	handler := func(ctx context.Context, d amqp.Delivery) {
+		_ = d.Ack(false)
+		actual, _ := ctx.Deadline()
+		coord <- actual
+	}
+	client.AddConsumer(exchange(), exchangeType(), queue, key, handler, 0)
+
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+
+	if err := client.PublishContext(ctx, key, []byte("this is a test")); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case actual := <-coord:
+		if !actual.Equal(deadline) {
+			t.Errorf("handler's context deadline was %v instead of %v", actual, deadline)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("no message delivered")
+	}
+}
+
+func TestPublishContextCancelledBeforePublish(t *testing.T) {
+	if !shouldrun() {
+		return
+	}
+
+	client := GetClient(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := client.PublishContext(ctx, "tests.deadline.cancelled", []byte("never sent")); !errors.Is(err, context.Canceled) {
+		t.Errorf("PublishContext with an already-cancelled context returned %v instead of context.Canceled", err)
+	}
+}
+
+func TestPublishContextAlreadyPastDeadline(t *testing.T) {
+	if !shouldrun() {
+		return
+	}
+
+	client := GetClient(t)
+
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Minute))
+	defer cancel()
+
+	if err := client.PublishContext(ctx, "tests.deadline.expired", []byte("never sent")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("PublishContext with an already-past deadline returned %v instead of context.DeadlineExceeded", err)
+	}
+}
@@ -0,0 +1,121 @@
+package messaging
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrDuplicate is returned by the dedup-aware publish methods when a
+// message with the same dedup key was already published within the
+// configured window. The publish is suppressed rather than sent.
+var ErrDuplicate = errors.New("messaging: duplicate publish suppressed")
+
+// DedupStore tracks which dedup keys have been seen recently, so that
+// duplicate publishes within a window can be suppressed. Implementations
+// must be safe for concurrent use.
+type DedupStore interface {
+	// SeenWithin records that key was seen and returns true if key was
+	// already recorded within the last ttl.
+	SeenWithin(key string, ttl time.Duration) (bool, error)
+
+	// Forget removes key's seen record, if any, so a later SeenWithin call
+	// for it reports false. It's used to roll back a SeenWithin call made
+	// for a publish that never actually went out, so a legitimate retry
+	// isn't suppressed as a duplicate for the rest of the TTL window.
+	Forget(key string) error
+}
+
+// WithDedup enables publish deduplication using store, suppressing any
+// publish whose dedup key was already seen within ttl. Without this
+// option, SendStopRequest, SendTimeLimitRequest, SendTimeLimitDelta, and
+// PublishWithKey never suppress duplicates.
+func WithDedup(store DedupStore, ttl time.Duration) ClientOption {
+	return func(c *Client) {
+		c.dedupStore = store
+		c.dedupTTL = ttl
+	}
+}
+
+// dedupKey returns the default dedup key for a publish of body under key:
+// the hex-encoded SHA-1 of key and body.
+func dedupKey(key string, body []byte) string {
+	sum := sha1.Sum([]byte(fmt.Sprintf("%s|%s", key, body)))
+	return hex.EncodeToString(sum[:])
+}
+
+// PublishWithKey publishes body under key, the same as Publish, except
+// that messageDedupKey (rather than the default derived from key and
+// body) is used to detect duplicates. If the client was configured with
+// WithDedup and a publish with the same messageDedupKey was already seen
+// within the configured window, ErrDuplicate is returned and nothing is
+// published.
+func (c *Client) PublishWithKey(key string, body []byte, messageDedupKey string) error {
+	return c.publishDeduped(context.Background(), key, body, messageDedupKey, 0)
+}
+
+// publishDeduped suppresses a publish of body under key with ErrDuplicate
+// if the client was configured with WithDedup and messageDedupKey was
+// already seen within the configured window, otherwise publishes body with
+// the given priority, with ctx governing the write as with PublishContext.
+// If the publish itself fails, messageDedupKey is forgotten again, so the
+// failed attempt doesn't permanently suppress a legitimate retry for the
+// rest of the TTL window.
+func (c *Client) publishDeduped(ctx context.Context, key string, body []byte, messageDedupKey string, priority uint8) error {
+	if c.dedupStore != nil {
+		seen, err := c.dedupStore.SeenWithin(messageDedupKey, c.dedupTTL)
+		if err != nil {
+			return err
+		}
+		if seen {
+			return ErrDuplicate
+		}
+	}
+
+	if err := c.publishContext(ctx, key, body, priority); err != nil {
+		if c.dedupStore != nil {
+			_ = c.dedupStore.Forget(messageDedupKey)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// publishJSONDeduped marshals v to JSON and publishes it under key, the
+// same as publishJSON, except that it's suppressed with ErrDuplicate if
+// the client was configured with WithDedup and an identical key/body
+// publish was already seen within the configured window.
+func (c *Client) publishJSONDeduped(key string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.publishDeduped(context.Background(), key, body, dedupKey(key, body), 0)
+}
+
+// publishJSONDedupedWithPriority is the same as publishJSONDeduped, except
+// that the message is published with the given priority so that a priority
+// queue can deliver it ahead of lower-priority messages already queued.
+func (c *Client) publishJSONDedupedWithPriority(key string, v interface{}, priority uint8) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.publishDeduped(context.Background(), key, body, dedupKey(key, body), priority)
+}
+
+// publishJSONDedupedContext is the same as publishJSONDeduped, except that
+// ctx governs the publish, as with PublishContext, and the message is
+// published with the given priority.
+func (c *Client) publishJSONDedupedContext(ctx context.Context, key string, v interface{}, priority uint8) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.publishDeduped(ctx, key, body, dedupKey(key, body), priority)
+}
@@ -0,0 +1,146 @@
+package messaging
+
+import (
+	"container/heap"
+	"container/list"
+	"sync"
+	"time"
+)
+
+// dedupEntry is a single key tracked by MemoryDedupStore. It belongs to
+// both an LRU list, for capacity-based eviction, and a min-heap ordered by
+// expiresAt, for TTL-based eviction.
+type dedupEntry struct {
+	key       string
+	expiresAt time.Time
+	heapIndex int
+	lruElem   *list.Element
+}
+
+// expiryHeap is a min-heap of *dedupEntry ordered by expiresAt, so the
+// next entry to expire is always at the root.
+type expiryHeap []*dedupEntry
+
+func (h expiryHeap) Len() int           { return len(h) }
+func (h expiryHeap) Less(i, j int) bool { return h[i].expiresAt.Before(h[j].expiresAt) }
+
+func (h expiryHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].heapIndex = i
+	h[j].heapIndex = j
+}
+
+func (h *expiryHeap) Push(x interface{}) {
+	entry := x.(*dedupEntry)
+	entry.heapIndex = len(*h)
+	*h = append(*h, entry)
+}
+
+func (h *expiryHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	entry := old[n-1]
+	old[n-1] = nil
+	entry.heapIndex = -1
+	*h = old[:n-1]
+	return entry
+}
+
+// MemoryDedupStore is an in-memory DedupStore. It evicts keys once their
+// TTL expires, using a min-heap to find expired keys cheaply, and also
+// bounds its size with an LRU list, for use in a single process without a
+// shared cache like Redis.
+type MemoryDedupStore struct {
+	mu       sync.Mutex
+	capacity int
+	entries  map[string]*dedupEntry
+	lru      *list.List
+	expiry   expiryHeap
+}
+
+// NewMemoryDedupStore returns a MemoryDedupStore that holds at most
+// capacity keys at once, evicting the least recently seen key once that
+// limit is reached. A non-positive capacity means unbounded.
+func NewMemoryDedupStore(capacity int) *MemoryDedupStore {
+	return &MemoryDedupStore{
+		capacity: capacity,
+		entries:  make(map[string]*dedupEntry),
+		lru:      list.New(),
+	}
+}
+
+// SeenWithin implements DedupStore.
+func (s *MemoryDedupStore) SeenWithin(key string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.evictExpired(now)
+
+	if entry, ok := s.entries[key]; ok {
+		s.lru.MoveToFront(entry.lruElem)
+		return true, nil
+	}
+
+	entry := &dedupEntry{key: key, expiresAt: now.Add(ttl)}
+	entry.lruElem = s.lru.PushFront(key)
+	s.entries[key] = entry
+	heap.Push(&s.expiry, entry)
+
+	s.evictOverCapacity()
+
+	return false, nil
+}
+
+// evictExpired removes every entry whose TTL has elapsed as of now.
+func (s *MemoryDedupStore) evictExpired(now time.Time) {
+	for s.expiry.Len() > 0 && !s.expiry[0].expiresAt.After(now) {
+		entry := heap.Pop(&s.expiry).(*dedupEntry)
+		s.removeEntry(entry)
+	}
+}
+
+// evictOverCapacity removes the least recently seen entries until the
+// store is back within capacity.
+func (s *MemoryDedupStore) evictOverCapacity() {
+	if s.capacity <= 0 {
+		return
+	}
+
+	for len(s.entries) > s.capacity {
+		oldest := s.lru.Back()
+		if oldest == nil {
+			return
+		}
+
+		entry, ok := s.entries[oldest.Value.(string)]
+		if !ok {
+			s.lru.Remove(oldest)
+			continue
+		}
+
+		heap.Remove(&s.expiry, entry.heapIndex)
+		s.removeEntry(entry)
+	}
+}
+
+func (s *MemoryDedupStore) removeEntry(entry *dedupEntry) {
+	delete(s.entries, entry.key)
+	s.lru.Remove(entry.lruElem)
+}
+
+// Forget implements DedupStore.
+func (s *MemoryDedupStore) Forget(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[key]
+	if !ok {
+		return nil
+	}
+
+	heap.Remove(&s.expiry, entry.heapIndex)
+	s.removeEntry(entry)
+
+	return nil
+}
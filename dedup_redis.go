@@ -0,0 +1,36 @@
+package messaging
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisDedupStore is a DedupStore backed by Redis, using `SET key NX PX
+// ttl` so that dedup state is shared across every process publishing
+// through the same broker, instead of being process-local like
+// MemoryDedupStore.
+type RedisDedupStore struct {
+	client *redis.Client
+}
+
+// NewRedisDedupStore returns a RedisDedupStore that records dedup keys in
+// client.
+func NewRedisDedupStore(client *redis.Client) *RedisDedupStore {
+	return &RedisDedupStore{client: client}
+}
+
+// SeenWithin implements DedupStore.
+func (s *RedisDedupStore) SeenWithin(key string, ttl time.Duration) (bool, error) {
+	set, err := s.client.SetNX(context.Background(), key, 1, ttl).Result()
+	if err != nil {
+		return false, err
+	}
+	return !set, nil
+}
+
+// Forget implements DedupStore.
+func (s *RedisDedupStore) Forget(key string) error {
+	return s.client.Del(context.Background(), key).Err()
+}
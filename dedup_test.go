@@ -0,0 +1,120 @@
+package messaging
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryDedupStoreSeenWithin(t *testing.T) {
+	store := NewMemoryDedupStore(10)
+
+	seen, err := store.SeenWithin("a", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seen {
+		t.Error("first SeenWithin call for a new key returned true")
+	}
+
+	seen, err = store.SeenWithin("a", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !seen {
+		t.Error("second SeenWithin call for the same key returned false")
+	}
+}
+
+func TestMemoryDedupStoreExpiry(t *testing.T) {
+	store := NewMemoryDedupStore(10)
+
+	if _, err := store.SeenWithin("a", 10*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+
+	seen, err := store.SeenWithin("a", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seen {
+		t.Error("SeenWithin returned true for a key whose TTL had already elapsed")
+	}
+}
+
+func TestMemoryDedupStoreCapacity(t *testing.T) {
+	store := NewMemoryDedupStore(2)
+
+	if _, err := store.SeenWithin("a", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.SeenWithin("b", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.SeenWithin("c", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	seen, err := store.SeenWithin("a", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seen {
+		t.Error("'a' should have been evicted once the store went over capacity")
+	}
+}
+
+func TestMemoryDedupStoreForget(t *testing.T) {
+	store := NewMemoryDedupStore(10)
+
+	if _, err := store.SeenWithin("a", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Forget("a"); err != nil {
+		t.Fatal(err)
+	}
+
+	seen, err := store.SeenWithin("a", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seen {
+		t.Error("SeenWithin returned true for a key that was forgotten")
+	}
+}
+
+func TestMemoryDedupStoreForgetUnknownKey(t *testing.T) {
+	store := NewMemoryDedupStore(10)
+
+	if err := store.Forget("never-seen"); err != nil {
+		t.Errorf("Forget on a key that was never seen returned %v instead of nil", err)
+	}
+}
+
+func TestSendStopRequestDedup(t *testing.T) {
+	if !shouldrun() {
+		return
+	}
+
+	dedupClient, err := NewClient(uri(), false, WithDedup(NewMemoryDedupStore(100), time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = dedupClient.Close() }()
+
+	if err = dedupClient.SetupPublishing(exchange()); err != nil {
+		t.Fatal(err)
+	}
+
+	invID := "dedup-test"
+	if err = dedupClient.SendStopRequest(invID, "test_user", "first"); err != nil {
+		t.Fatal(err)
+	}
+
+	err = dedupClient.SendStopRequest(invID, "test_user", "first")
+	if err != ErrDuplicate {
+		t.Errorf("repeated SendStopRequest returned %v instead of ErrDuplicate", err)
+	}
+}
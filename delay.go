@@ -0,0 +1,198 @@
+package messaging
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// DelayedMessageBackend selects the mechanism used by PublishAt and
+// PublishIn to delay delivery of a message.
+type DelayedMessageBackend int
+
+const (
+	// DelayedMessageFallback schedules messages using a per-(key, delay)
+	// TTL queue whose dead-letter exchange and routing key point back at
+	// the real exchange and the message's original key. It works on any
+	// broker, without the x-delayed-message plugin installed, and is the
+	// default backend.
+	DelayedMessageFallback DelayedMessageBackend = iota
+
+	// DelayedMessagePlugin schedules messages using the RabbitMQ
+	// x-delayed-message exchange plugin. SetupPublishing must be called
+	// after selecting this backend so that the exchange is declared with
+	// the plugin's required arguments.
+	DelayedMessagePlugin
+)
+
+// ClientOption configures optional behavior on a Client. Options are
+// applied by NewClient in the order they're given.
+type ClientOption func(*Client)
+
+// WithDelayedMessageBackend selects the backend used to implement
+// PublishAt and PublishIn. The default, if this option isn't given, is
+// DelayedMessageFallback.
+func WithDelayedMessageBackend(backend DelayedMessageBackend) ClientOption {
+	return func(c *Client) {
+		c.delayedBackend = backend
+	}
+}
+
+// delayTiers bounds the number of distinct TTL queues the fallback backend
+// ever declares for a given (exchange, key) pair. Without it, delayQueueName
+// would derive an almost-unique queue name per call, since PublishAt derives
+// d from time.Until(at), which shifts by the millisecond on every call even
+// for the same target time, leaking an ever-growing number of durable
+// queues on the broker. Each tier is a ceiling: a message is never delivered
+// earlier than requested, only up to one tier later.
+var delayTiers = []time.Duration{
+	time.Second,
+	5 * time.Second,
+	10 * time.Second,
+	30 * time.Second,
+	time.Minute,
+	5 * time.Minute,
+	15 * time.Minute,
+	30 * time.Minute,
+	time.Hour,
+	6 * time.Hour,
+	12 * time.Hour,
+	24 * time.Hour,
+}
+
+// delayBucket rounds d up to the smallest tier in delayTiers that's at
+// least d, or up to the next whole day beyond the largest tier if d exceeds
+// all of them.
+func delayBucket(d time.Duration) time.Duration {
+	for _, tier := range delayTiers {
+		if d <= tier {
+			return tier
+		}
+	}
+
+	bucket := delayTiers[len(delayTiers)-1]
+	for d > bucket {
+		bucket += 24 * time.Hour
+	}
+	return bucket
+}
+
+// delayQueueName returns the name of the TTL queue used by the fallback
+// backend to hold a message bound for key for roughly d before it's
+// dead-lettered back to exchange. d is bucketed with delayBucket first, so
+// repeated calls with nearby delays share the same queue instead of each
+// declaring a new one.
+func delayQueueName(exchange, key string, d time.Duration) string {
+	return fmt.Sprintf("%s.delay.%s.%dms", exchange, key, delayBucket(d).Milliseconds())
+}
+
+// ensureDelayQueue declares, if necessary, the TTL queue that will hold a
+// message bound for key for roughly d (see delayBucket) before it's
+// dead-lettered back to the real exchange under its original key.
+func (c *Client) ensureDelayQueue(key string, d time.Duration) (string, error) {
+	c.mu.RLock()
+	exchange := c.exchange
+	c.mu.RUnlock()
+
+	bucket := delayBucket(d)
+	queue := delayQueueName(exchange, key, d)
+
+	_, err := c.channel().QueueDeclare(
+		queue,
+		true,
+		false,
+		false,
+		false,
+		amqp.Table{
+			"x-message-ttl":             bucket.Milliseconds(),
+			"x-dead-letter-exchange":    exchange,
+			"x-dead-letter-routing-key": key,
+		},
+	)
+	if err != nil {
+		return "", err
+	}
+
+	return queue, nil
+}
+
+// PublishAt publishes body under key so that it isn't delivered to
+// consumers until at. See PublishIn for details on how the delay is
+// implemented.
+func (c *Client) PublishAt(key string, body []byte, at time.Time) error {
+	return c.PublishIn(key, body, time.Until(at))
+}
+
+// PublishIn publishes body under key so that it isn't delivered to
+// consumers until d has elapsed. Depending on the backend selected with
+// WithDelayedMessageBackend, the delay is implemented with either the
+// x-delayed-message exchange plugin, which honors d exactly, or a per-key
+// TTL queue whose dead-letter exchange points back at the configured
+// exchange; the TTL queue's delay is bucketed with delayBucket, so it may
+// deliver up to one tier later than d, in exchange for a bounded number of
+// TTL queues instead of a new one per call. A non-positive d publishes
+// immediately.
+func (c *Client) PublishIn(key string, body []byte, d time.Duration) error {
+	if d <= 0 {
+		return c.Publish(key, body)
+	}
+
+	if err := c.awaitConnected(); err != nil {
+		return err
+	}
+
+	publishing := amqp.Publishing{
+		DeliveryMode: amqp.Persistent,
+		Timestamp:    time.Now(),
+		ContentType:  "application/json",
+		Body:         body,
+	}
+
+	c.mu.RLock()
+	exchange := c.exchange
+	c.mu.RUnlock()
+
+	if c.delayedBackend == DelayedMessagePlugin {
+		publishing.Headers = amqp.Table{"x-delay": d.Milliseconds()}
+		return c.channel().Publish(exchange, key, false, false, publishing)
+	}
+
+	queue, err := c.ensureDelayQueue(key, d)
+	if err != nil {
+		return err
+	}
+
+	return c.channel().Publish("", queue, false, false, publishing)
+}
+
+// publishJSONIn marshals v to JSON and schedules it for delivery under key
+// after d has elapsed.
+func (c *Client) publishJSONIn(key string, v interface{}, d time.Duration) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.PublishIn(key, body, d)
+}
+
+// ScheduleStopRequest schedules a request to stop the job with the given
+// invocation ID to be delivered after d has elapsed, instead of
+// immediately as SendStopRequest does.
+func (c *Client) ScheduleStopRequest(invID, username, reason string, d time.Duration) error {
+	return c.publishJSONIn(StopRequestKey(invID), &StopRequest{
+		Version:      0,
+		InvocationID: invID,
+		Username:     username,
+		Reason:       reason,
+	}, d)
+}
+
+// SchedulePublishJobUpdate schedules a job status update to be delivered
+// after d has elapsed, instead of immediately as PublishJobUpdate does.
+// SentOn is set to the current time as a side-effect.
+func (c *Client) SchedulePublishJobUpdate(update *UpdateMessage, d time.Duration) error {
+	update.SentOn = time.Now().UnixNano() / int64(time.Millisecond)
+	return c.publishJSONIn(UpdatesKey, update, d)
+}
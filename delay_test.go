@@ -0,0 +1,102 @@
+package messaging
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestDelayBucket(t *testing.T) {
+	cases := []struct {
+		in       time.Duration
+		expected time.Duration
+	}{
+		{500 * time.Millisecond, time.Second},
+		{time.Second, time.Second},
+		{3 * time.Second, 5 * time.Second},
+		{90 * time.Second, 5 * time.Minute},
+		{25 * time.Hour, 48 * time.Hour},
+	}
+
+	for _, c := range cases {
+		if actual := delayBucket(c.in); actual != c.expected {
+			t.Errorf("delayBucket(%s) was %s instead of %s", c.in, actual, c.expected)
+		}
+	}
+}
+
+func TestPublishIn(t *testing.T) {
+	queue := "test_delay_queue"
+	key := "tests.delay"
+	expected := []byte("delayed message")
+	delay := 2 * time.Second
+
+	var sentAt time.Time
+	publish := func(c *Client) {
+		sentAt = time.Now()
+		if err := c.PublishIn(key, expected, delay); err != nil {
+			t.Error(err)
+		}
+	}
+
+	check := func(actual []byte) {
+		if time.Since(sentAt) < delay {
+			t.Errorf("message arrived after %s, before the requested delay of %s", time.Since(sentAt), delay)
+		}
+		if string(actual) != string(expected) {
+			t.Errorf("handler received %s instead of %s", actual, expected)
+		}
+	}
+
+	runPublishingTest(t, queue, key, publish, check)
+}
+
+func TestPublishInWithDelayedMessagePlugin(t *testing.T) {
+	if !shouldrun() {
+		return
+	}
+
+	pluginClient, err := NewClient(uri(), false, WithDelayedMessageBackend(DelayedMessagePlugin))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = pluginClient.Close() }()
+
+	// The other tests declare exchange() as a plain topic exchange, so the
+	// delayed exchange needs a name of its own.
+	delayedExchange := exchange() + "_delayed"
+	if err = pluginClient.SetupPublishing(delayedExchange); err != nil {
+		t.Fatal(err)
+	}
+
+	queue := "test_delay_plugin_queue"
+	key := "tests.delay.plugin"
+	expected := []byte("delayed message via plugin")
+	delay := 2 * time.Second
+
+	actual := make([]byte, 0)
+	coord := make(chan int)
+	handler := func(_ context.Context, d amqp.Delivery) {
+		_ = d.Ack(false)
+		actual = d.Body
+		coord <- 1
+	}
+
+	pluginClient.AddConsumer(delayedExchange, exchangeType(), queue, key, handler, 0)
+	go func() { _ = pluginClient.Listen() }()
+
+	sentAt := time.Now()
+	if err = pluginClient.PublishIn(key, expected, delay); err != nil {
+		t.Fatal(err)
+	}
+	<-coord
+
+	if time.Since(sentAt) < delay {
+		t.Errorf("message arrived after %s, before the requested delay of %s", time.Since(sentAt), delay)
+	}
+	if string(actual) != string(expected) {
+		t.Errorf("handler received %s instead of %s", actual, expected)
+	}
+}
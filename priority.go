@@ -0,0 +1,98 @@
+package messaging
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// MaxPriority is the highest message priority used anywhere in this
+// package. It's the priority SendStopRequest publishes with, and a
+// reasonable x-max-priority to pass to
+// CreatePriorityQueue for a queue that a stop request might be published
+// to. RabbitMQ recommends keeping the number of priority levels a queue
+// supports low, since each level costs the broker additional overhead.
+const MaxPriority uint8 = 10
+
+// ErrQueueExistsWithoutPriority is returned by CreatePriorityQueue, and
+// reported by AddPriorityConsumer, when the broker refuses to declare the
+// priority queue because a queue with the requested name already exists with
+// different arguments, such as a different (or no) x-max-priority. RabbitMQ
+// doesn't allow a queue's arguments to change once it's declared, so the
+// existing queue must be drained and deleted before it can be recreated as
+// a priority queue.
+var ErrQueueExistsWithoutPriority = errors.New("messaging: queue already exists with a different priority configuration")
+
+// priorityArgs returns the x-max-priority queue argument for maxPriority.
+func priorityArgs(maxPriority uint8) amqp.Table {
+	return amqp.Table{"x-max-priority": int(maxPriority)}
+}
+
+// isPreconditionFailed returns true if err is the AMQP channel exception
+// the broker raises when a queue is redeclared with arguments that don't
+// match its existing declaration.
+func isPreconditionFailed(err error) bool {
+	var amqpErr *amqp.Error
+	if errors.As(err, &amqpErr) {
+		return amqpErr.Code == amqp.PreconditionFailed
+	}
+	return false
+}
+
+// CreatePriorityQueue declares a priority queue with the given name,
+// durability, and auto-delete settings, supporting maxPriority distinct
+// priority levels, binds it to exchange using key as the routing key, and
+// returns the channel it was declared on. The caller is responsible for
+// closing the returned channel. If a queue with the given name already
+// exists without this priority configuration, ErrQueueExistsWithoutPriority
+// is returned so an operator can decide when to drain and recreate it,
+// rather than the broker closing the channel with an opaque error.
+func (c *Client) CreatePriorityQueue(name, exchange, key string, maxPriority uint8, durable, autoDelete bool) (*amqp.Channel, error) {
+	channel, err := c.conn().Channel()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = channel.QueueDeclare(name, durable, autoDelete, false, false, priorityArgs(maxPriority)); err != nil {
+		if isPreconditionFailed(err) {
+			return nil, fmt.Errorf("%w: %s", ErrQueueExistsWithoutPriority, name)
+		}
+		return nil, err
+	}
+
+	if err = channel.QueueBind(name, key, exchange, false, nil); err != nil {
+		return nil, err
+	}
+
+	return channel, nil
+}
+
+// AddPriorityConsumer is the same as AddConsumer, except that queue is
+// declared as a priority queue supporting maxPriority distinct priority
+// levels, so that higher-priority deliveries, such as those published with
+// SendStopRequest, can preempt a backlog of lower-priority ones already
+// queued. If a queue with the same name already exists without this
+// priority configuration, the consumer fails to start with
+// ErrQueueExistsWithoutPriority, reported the same way AddConsumer reports
+// any other startup failure.
+func (c *Client) AddPriorityConsumer(exchange, exchangeType, queue, key string, handler MessageHandler, prefetch int, maxPriority uint8) {
+	reg := consumerRegistration{
+		exchange:     exchange,
+		exchangeType: exchangeType,
+		queue:        queue,
+		key:          key,
+		handler:      handler,
+		prefetch:     prefetch,
+		maxPriority:  maxPriority,
+	}
+
+	c.mu.Lock()
+	c.consumers = append(c.consumers, reg)
+	c.mu.Unlock()
+
+	if err := c.startConsumer(reg); err != nil {
+		fmt.Fprintf(os.Stderr, "messaging: failed to start priority consumer for queue %s: %s\n", queue, err)
+	}
+}
@@ -0,0 +1,144 @@
+package messaging
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestCreatePriorityQueueRefusesNonPriorityQueue(t *testing.T) {
+	if !shouldrun() {
+		return
+	}
+
+	client, err := NewClient(uri(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+
+	if err = client.SetupPublishing(exchange()); err != nil {
+		t.Fatal(err)
+	}
+
+	queue := "test_priority_migration_queue"
+	defer func() { _ = client.DeleteQueue(queue) }()
+
+	if _, err = client.CreateQueue(queue, exchange(), "tests.priority.migration", false, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = client.CreatePriorityQueue(queue, exchange(), "tests.priority.migration", MaxPriority, false, false); !errors.Is(err, ErrQueueExistsWithoutPriority) {
+		t.Errorf("CreatePriorityQueue returned %v instead of ErrQueueExistsWithoutPriority", err)
+	}
+}
+
+func TestSendStopRequestPreemptsQueuedUpdates(t *testing.T) {
+	if !shouldrun() {
+		return
+	}
+
+	client, err := NewClient(uri(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+
+	if err = client.SetupPublishing(exchange()); err != nil {
+		t.Fatal(err)
+	}
+
+	invID := "priority-test"
+	queue := StopQueueName(invID)
+	key := StopRequestKey(invID)
+	defer func() { _ = client.DeleteQueue(queue) }()
+
+	if _, err = client.CreatePriorityQueue(queue, exchange(), key, MaxPriority, true, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// Queue up a low-priority request before the high-priority one, so that
+	// delivery order proves the stop request preempted it rather than just
+	// arriving first.
+	if err = client.PublishWithPriority(key, []byte(`{"Reason":"routine"}`), 1); err != nil {
+		t.Fatal(err)
+	}
+	if err = client.SendStopRequest(invID, "test_user", "urgent"); err != nil {
+		t.Fatal(err)
+	}
+
+	delivered := make(chan string, 2)
+	handler := func(_ context.Context, d amqp.Delivery) {
+		_ = d.Ack(false)
+		req := &StopRequest{}
+		_ = json.Unmarshal(d.Body, req)
+		delivered <- req.Reason
+	}
+	client.AddPriorityConsumer(exchange(), exchangeType(), queue, key, handler, 0, MaxPriority)
+
+	select {
+	case reason := <-delivered:
+		if reason != "urgent" {
+			t.Errorf("first delivery had Reason %q instead of %q", reason, "urgent")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("no message delivered")
+	}
+}
+
+func TestSendTimeLimitDeltaWithPriorityPreemptsQueuedUpdates(t *testing.T) {
+	if !shouldrun() {
+		return
+	}
+
+	client, err := NewClient(uri(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+
+	if err = client.SetupPublishing(exchange()); err != nil {
+		t.Fatal(err)
+	}
+
+	invID := "priority-delta-test"
+	queue := TimeLimitDeltaQueueName(invID)
+	key := TimeLimitDeltaRequestKey(invID)
+	defer func() { _ = client.DeleteQueue(queue) }()
+
+	if _, err = client.CreatePriorityQueue(queue, exchange(), key, MaxPriority, true, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// Queue up a low-priority delta before the urgent one, so that delivery
+	// order proves the urgent delta preempted it rather than just arriving
+	// first.
+	if err = client.PublishWithPriority(key, []byte(`{"Delta":"routine"}`), 1); err != nil {
+		t.Fatal(err)
+	}
+	if err = client.SendTimeLimitDeltaWithPriority(invID, "urgent", MaxPriority); err != nil {
+		t.Fatal(err)
+	}
+
+	delivered := make(chan string, 2)
+	handler := func(_ context.Context, d amqp.Delivery) {
+		_ = d.Ack(false)
+		delta := &TimeLimitDelta{}
+		_ = json.Unmarshal(d.Body, delta)
+		delivered <- delta.Delta
+	}
+	client.AddPriorityConsumer(exchange(), exchangeType(), queue, key, handler, 0, MaxPriority)
+
+	select {
+	case delta := <-delivered:
+		if delta != "urgent" {
+			t.Errorf("first delivery had Delta %q instead of %q", delta, "urgent")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("no message delivered")
+	}
+}
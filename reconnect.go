@@ -0,0 +1,276 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// ConnectionState describes the current state of a Client's connection to
+// the broker.
+type ConnectionState int
+
+const (
+	// Connected means the client has a working connection to the broker.
+	Connected ConnectionState = iota
+
+	// Reconnecting means the connection dropped and the client is
+	// attempting to re-establish it.
+	Reconnecting
+
+	// Closed means the client's connection is shut down for good, either
+	// because Close was called or, for a client created with reconnect set
+	// to false, because the connection dropped.
+	Closed
+)
+
+// DisconnectBehavior selects what Publish does while the client is
+// Reconnecting.
+type DisconnectBehavior int
+
+const (
+	// ReturnErrDisconnected makes Publish return ErrDisconnected
+	// immediately while the client is reconnecting. This is the default.
+	ReturnErrDisconnected DisconnectBehavior = iota
+
+	// BlockUntilReconnect makes Publish block until the connection is
+	// restored before publishing.
+	BlockUntilReconnect
+)
+
+// ErrDisconnected is returned by Publish when the client is disconnected
+// from the broker and was configured with ReturnErrDisconnected.
+var ErrDisconnected = errors.New("messaging: client is disconnected from the broker")
+
+// Bounded exponential backoff used between reconnect attempts.
+const (
+	reconnectInitialBackoff = 500 * time.Millisecond
+	reconnectMaxBackoff     = 30 * time.Second
+	reconnectMultiplier     = 2.0
+)
+
+// WithDisconnectBehavior selects what Publish does while the client is
+// reconnecting. The default is ReturnErrDisconnected.
+func WithDisconnectBehavior(behavior DisconnectBehavior) ClientOption {
+	return func(c *Client) {
+		c.disconnectBehavior = behavior
+	}
+}
+
+// ConnectionState returns the client's current connection state.
+func (c *Client) ConnectionState() ConnectionState {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.state
+}
+
+// NotifyReconnect returns a channel that receives a value the next time the
+// client successfully reconnects to the broker. Each call returns a new,
+// single-use channel.
+func (c *Client) NotifyReconnect() <-chan struct{} {
+	ch := make(chan struct{}, 1)
+
+	c.mu.Lock()
+	c.reconnectSubs = append(c.reconnectSubs, ch)
+	c.mu.Unlock()
+
+	return ch
+}
+
+// markClosed closes closedCh, waking up any goroutine blocked in Listen.
+func (c *Client) markClosed() {
+	c.closeOnce.Do(func() { close(c.closedCh) })
+}
+
+// awaitConnected returns nil once the client is connected, returns
+// ErrDisconnected if the client is permanently closed or configured with
+// ReturnErrDisconnected, or blocks until reconnected if configured with
+// BlockUntilReconnect.
+func (c *Client) awaitConnected() error {
+	return c.awaitConnectedContext(context.Background())
+}
+
+// awaitConnectedContext is the same as awaitConnected, except that while
+// blocked waiting for BlockUntilReconnect to reconnect, it also returns
+// ctx.Err() as soon as ctx is done.
+func (c *Client) awaitConnectedContext(ctx context.Context) error {
+	c.mu.Lock()
+
+	switch c.state {
+	case Connected:
+		c.mu.Unlock()
+		return nil
+	case Closed:
+		c.mu.Unlock()
+		return ErrDisconnected
+	}
+
+	if c.disconnectBehavior == ReturnErrDisconnected {
+		c.mu.Unlock()
+		return ErrDisconnected
+	}
+
+	ch := make(chan struct{}, 1)
+	c.reconnectSubs = append(c.reconnectSubs, ch)
+	c.mu.Unlock()
+
+	select {
+	case <-ch:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// superviseConnection watches the client's connection for an unexpected
+// close and, if the client was created with reconnect set to true, runs a
+// backoff loop to re-dial the broker and restore publishing and every
+// registered consumer. It runs for the lifetime of the client.
+func (c *Client) superviseConnection() {
+	for {
+		closed := make(chan *amqp.Error, 1)
+		c.conn().NotifyClose(closed)
+		<-closed
+
+		c.handleDisconnect()
+
+		c.mu.RLock()
+		closedForGood := c.state == Closed
+		c.mu.RUnlock()
+		if closedForGood {
+			return
+		}
+	}
+}
+
+// watchPublishingChannel waits for channel to close and, when it does,
+// triggers the same recovery handleDisconnect runs for a dropped
+// connection. SetupPublishing starts one of these for every channel it
+// opens, since a channel-level protocol exception can close the publishing
+// channel without closing the underlying connection, which
+// superviseConnection wouldn't otherwise notice.
+func (c *Client) watchPublishingChannel(channel *amqp.Channel) {
+	closed := make(chan *amqp.Error, 1)
+	channel.NotifyClose(closed)
+	<-closed
+
+	// If channel has already been superseded by a newer one, this close was
+	// a side effect of handleDisconnect tearing down the old connection
+	// during a recovery some other trigger already started, not a fresh
+	// failure.
+	if c.channel() != channel {
+		return
+	}
+
+	c.handleDisconnect()
+}
+
+// handleDisconnect runs the recovery logic shared by superviseConnection
+// and watchPublishingChannel: if the client is already Closed or
+// Reconnecting, it's a no-op, since the other trigger got there first or
+// recovery is already underway. Otherwise, if the client wasn't created
+// with reconnect set to true, it's closed for good; if it was, the
+// connection (and everything built on it, including the publishing channel
+// and every consumer) is torn down and rebuilt from scratch by
+// reconnectLoop.
+func (c *Client) handleDisconnect() {
+	c.mu.Lock()
+	if c.state != Connected {
+		c.mu.Unlock()
+		return
+	}
+
+	if !c.reconnect {
+		c.state = Closed
+		c.mu.Unlock()
+		c.markClosed()
+		return
+	}
+
+	c.state = Reconnecting
+	conn := c.connection
+	c.mu.Unlock()
+
+	_ = conn.Close()
+
+	c.reconnectLoop()
+}
+
+// reconnectLoop re-dials uri with a bounded exponential backoff until it
+// succeeds in restoring the connection, the publishing channel, and every
+// consumer registered with AddConsumer, or until Close is called.
+func (c *Client) reconnectLoop() {
+	backoff := reconnectInitialBackoff
+
+	for {
+		time.Sleep(backoff)
+
+		if c.ConnectionState() == Closed {
+			return
+		}
+
+		if err := c.reconnectOnce(); err == nil {
+			c.setConnected()
+			return
+		}
+
+		backoff = time.Duration(float64(backoff) * reconnectMultiplier)
+		if backoff > reconnectMaxBackoff {
+			backoff = reconnectMaxBackoff
+		}
+	}
+}
+
+// reconnectOnce dials a fresh connection and, if that succeeds, restores
+// publishing and every registered consumer on it.
+func (c *Client) reconnectOnce() error {
+	connection, err := amqp.Dial(c.uri)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.connection = connection
+	exchange := c.exchange
+	consumers := append([]consumerRegistration(nil), c.consumers...)
+	c.mu.Unlock()
+
+	if exchange != "" {
+		if err := c.SetupPublishing(exchange); err != nil {
+			_ = connection.Close()
+			return err
+		}
+	}
+
+	for _, reg := range consumers {
+		if err := c.startConsumer(reg); err != nil {
+			_ = connection.Close()
+			return err
+		}
+	}
+
+	return nil
+}
+
+// setConnected marks the client Connected and wakes up anything blocked in
+// awaitConnected or waiting on a channel from NotifyReconnect. If Close was
+// called while reconnecting, the new connection is closed instead.
+func (c *Client) setConnected() {
+	c.mu.Lock()
+	if c.state == Closed {
+		conn := c.connection
+		c.mu.Unlock()
+		_ = conn.Close()
+		return
+	}
+	c.state = Connected
+	subs := c.reconnectSubs
+	c.reconnectSubs = nil
+	c.mu.Unlock()
+
+	for _, ch := range subs {
+		ch <- struct{}{}
+	}
+}
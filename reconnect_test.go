@@ -0,0 +1,120 @@
+package messaging
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestReconnectAfterConnectionDrop(t *testing.T) {
+	if !shouldrun() {
+		return
+	}
+
+	reconnectingClient, err := NewClient(uri(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = reconnectingClient.Close() }()
+
+	if err = reconnectingClient.SetupPublishing(exchange()); err != nil {
+		t.Fatal(err)
+	}
+
+	queue := "test_reconnect_queue"
+	key := "tests.reconnect"
+	expected := []byte("this survived a reconnect")
+
+	coord := make(chan int, 1)
+	handler := func(_ context.Context, d amqp.Delivery) {
+		_ = d.Ack(false)
+		coord <- 1
+	}
+	reconnectingClient.AddConsumer(exchange(), exchangeType(), queue, key, handler, 0)
+
+	reconnectNotify := reconnectingClient.NotifyReconnect()
+
+	// Simulate a broker restart by forcibly closing the client's
+	// underlying connection out from under it.
+	if err = reconnectingClient.conn().Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-reconnectNotify:
+	case <-time.After(40 * time.Second):
+		t.Fatal("client never reconnected")
+	}
+
+	if reconnectingClient.ConnectionState() != Connected {
+		t.Errorf("ConnectionState was %v instead of Connected after reconnecting", reconnectingClient.ConnectionState())
+	}
+
+	if err = reconnectingClient.Publish(key, expected); err != nil {
+		t.Error(err)
+	}
+
+	select {
+	case <-coord:
+	case <-time.After(10 * time.Second):
+		t.Fatal("message published after reconnecting was never delivered")
+	}
+}
+
+func TestReconnectAfterPublishingChannelDrop(t *testing.T) {
+	if !shouldrun() {
+		return
+	}
+
+	reconnectingClient, err := NewClient(uri(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = reconnectingClient.Close() }()
+
+	if err = reconnectingClient.SetupPublishing(exchange()); err != nil {
+		t.Fatal(err)
+	}
+
+	queue := "test_reconnect_channel_queue"
+	key := "tests.reconnect.channel"
+	expected := []byte("this survived a channel-only drop")
+
+	coord := make(chan int, 1)
+	handler := func(_ context.Context, d amqp.Delivery) {
+		_ = d.Ack(false)
+		coord <- 1
+	}
+	reconnectingClient.AddConsumer(exchange(), exchangeType(), queue, key, handler, 0)
+
+	reconnectNotify := reconnectingClient.NotifyReconnect()
+
+	// Simulate a channel-level protocol exception, which closes the
+	// publishing channel without closing the connection, by closing it
+	// directly.
+	if err = reconnectingClient.channel().Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-reconnectNotify:
+	case <-time.After(40 * time.Second):
+		t.Fatal("client never recovered from a publishing channel drop")
+	}
+
+	if reconnectingClient.ConnectionState() != Connected {
+		t.Errorf("ConnectionState was %v instead of Connected after recovering", reconnectingClient.ConnectionState())
+	}
+
+	if err = reconnectingClient.Publish(key, expected); err != nil {
+		t.Error(err)
+	}
+
+	select {
+	case <-coord:
+	case <-time.After(10 * time.Second):
+		t.Fatal("message published after recovering was never delivered")
+	}
+}
@@ -0,0 +1,212 @@
+package messaging
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// retryCountHeader tracks how many times a delivery has been retried.
+const retryCountHeader = "x-retry-count"
+
+// originalExchangeHeader and originalKeyHeader record where a delivery was
+// published before it was routed to a dead-letter queue, so
+// ReplayDeadLetters knows where to put it back.
+const (
+	originalExchangeHeader = "x-original-exchange"
+	originalKeyHeader      = "x-original-key"
+)
+
+// RetryHandler is a delivery handler that reports transient failure by
+// returning an error, in which case the delivery is retried with
+// exponential backoff instead of being acked or re-queued immediately.
+type RetryHandler func(context.Context, amqp.Delivery) error
+
+// RetryOptions configures the backoff and dead-lettering behavior of a
+// consumer registered with AddRetryConsumer.
+type RetryOptions struct {
+	// MaxRetries is the number of times a failing delivery is retried
+	// before it's routed to DeadLetterQueue.
+	MaxRetries int
+
+	// InitialBackoff is the delay before the first retry.
+	InitialBackoff time.Duration
+
+	// MaxBackoff caps the delay between retries, no matter how many
+	// retries have already happened.
+	MaxBackoff time.Duration
+
+	// Multiplier is applied to the backoff delay after each retry.
+	Multiplier float64
+
+	// DeadLetterQueue is the name of the queue a delivery is routed to
+	// once MaxRetries has been exceeded.
+	DeadLetterQueue string
+}
+
+// backoff returns the (jittered) delay to use before the given retry
+// attempt, where attempt 1 is the first retry.
+func (o RetryOptions) backoff(attempt int) time.Duration {
+	d := float64(o.InitialBackoff) * math.Pow(o.Multiplier, float64(attempt-1))
+
+	jitter := 0.5 + rand.Float64() // somewhere between 0.5x and 1.5x
+	d *= jitter
+
+	if o.MaxBackoff > 0 && d > float64(o.MaxBackoff) {
+		d = float64(o.MaxBackoff)
+	}
+	return time.Duration(d)
+}
+
+// retryQueueName returns the name of the TTL queue used to hold a delivery
+// bound for key for the backoff delay preceding the given retry attempt.
+func retryQueueName(exchange, key string, attempt int) string {
+	return fmt.Sprintf("%s.retry.%s.attempt%d", exchange, key, attempt)
+}
+
+// retryCount returns the value of retryCountHeader in headers, or 0 if it
+// isn't set.
+func retryCount(headers amqp.Table) int {
+	if headers == nil {
+		return 0
+	}
+	switch v := headers[retryCountHeader].(type) {
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	case int:
+		return v
+	default:
+		return 0
+	}
+}
+
+// AddRetryConsumer registers handler to be called for every delivery
+// received on queue, the same as AddConsumer, except that a handler
+// returning an error causes the delivery to be Nacked and republished to a
+// per-key retry queue with a TTL computed from opts using exponential
+// backoff, rather than being immediately re-queued. The retry queue's
+// dead-letter exchange and routing key point back at exchange and key, so
+// the delivery lands back on queue once the backoff elapses. Once
+// opts.MaxRetries has been exceeded, the delivery is routed to
+// opts.DeadLetterQueue instead of being retried again.
+func (c *Client) AddRetryConsumer(exchange, exchangeType, queue, key string, handler RetryHandler, opts RetryOptions) {
+	wrapped := func(ctx context.Context, d amqp.Delivery) {
+		if err := handler(ctx, d); err == nil {
+			_ = d.Ack(false)
+			return
+		}
+
+		attempt := retryCount(d.Headers) + 1
+		_ = d.Nack(false, false)
+
+		if attempt > opts.MaxRetries {
+			if err := c.sendToDeadLetterQueue(opts.DeadLetterQueue, exchange, key, d); err != nil {
+				_ = c.Publish(key, d.Body)
+			}
+			return
+		}
+
+		if err := c.scheduleRetry(exchange, key, attempt, opts.backoff(attempt), d); err != nil {
+			_ = c.Publish(key, d.Body)
+		}
+	}
+
+	c.AddConsumer(exchange, exchangeType, queue, key, wrapped, 0)
+}
+
+// scheduleRetry declares (if necessary) the retry queue for the given
+// attempt and publishes d.Body to it with backoff as its per-message TTL, so
+// that it's dead-lettered back to exchange under key once backoff has
+// elapsed. The TTL is set on the message rather than the queue, since
+// backoff is jittered and the queue's arguments must match on every
+// redeclaration.
+func (c *Client) scheduleRetry(exchange, key string, attempt int, backoff time.Duration, d amqp.Delivery) error {
+	queue := retryQueueName(exchange, key, attempt)
+
+	if _, err := c.channel().QueueDeclare(
+		queue,
+		true,
+		false,
+		false,
+		false,
+		amqp.Table{
+			"x-dead-letter-exchange":    exchange,
+			"x-dead-letter-routing-key": key,
+		},
+	); err != nil {
+		return err
+	}
+
+	return c.channel().Publish("", queue, false, false, amqp.Publishing{
+		DeliveryMode: amqp.Persistent,
+		Timestamp:    time.Now(),
+		ContentType:  d.ContentType,
+		Expiration:   strconv.FormatInt(backoff.Milliseconds(), 10),
+		Headers:      amqp.Table{retryCountHeader: int32(attempt)},
+		Body:         d.Body,
+	})
+}
+
+// sendToDeadLetterQueue declares (if necessary) queue and publishes d.Body
+// to it, recording exchange and key as headers so ReplayDeadLetters can
+// restore them later.
+func (c *Client) sendToDeadLetterQueue(queue, exchange, key string, d amqp.Delivery) error {
+	if _, err := c.channel().QueueDeclare(queue, true, false, false, false, nil); err != nil {
+		return err
+	}
+
+	return c.channel().Publish("", queue, false, false, amqp.Publishing{
+		DeliveryMode: amqp.Persistent,
+		Timestamp:    time.Now(),
+		ContentType:  d.ContentType,
+		Headers: amqp.Table{
+			originalExchangeHeader: exchange,
+			originalKeyHeader:      key,
+		},
+		Body: d.Body,
+	})
+}
+
+// ReplayDeadLetters moves up to max messages from queue back onto the
+// exchange and routing key they originally failed from, so an operator can
+// retry deliveries that ended up dead-lettered. It returns the number of
+// messages replayed.
+func (c *Client) ReplayDeadLetters(queue string, max int) (int, error) {
+	replayed := 0
+
+	for replayed < max {
+		d, ok, err := c.channel().Get(queue, false)
+		if err != nil {
+			return replayed, err
+		}
+		if !ok {
+			break
+		}
+
+		exchange, _ := d.Headers[originalExchangeHeader].(string)
+		key, _ := d.Headers[originalKeyHeader].(string)
+
+		err = c.channel().Publish(exchange, key, false, false, amqp.Publishing{
+			DeliveryMode: amqp.Persistent,
+			Timestamp:    time.Now(),
+			ContentType:  d.ContentType,
+			Body:         d.Body,
+		})
+		if err != nil {
+			_ = d.Nack(false, true)
+			return replayed, err
+		}
+
+		_ = d.Ack(false)
+		replayed++
+	}
+
+	return replayed, nil
+}
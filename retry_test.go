@@ -0,0 +1,101 @@
+package messaging
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestAddRetryConsumerSucceedsAfterRetries(t *testing.T) {
+	if !shouldrun() {
+		return
+	}
+
+	queue := "test_retry_queue"
+	key := "tests.retry"
+	expected := []byte("this is a retried test")
+
+	attempts := 0
+	coord := make(chan int)
+	handler := func(_ context.Context, d amqp.Delivery) error {
+		attempts++
+		if attempts < 3 {
+			return fmt.Errorf("attempt %d failed", attempts)
+		}
+		coord <- attempts
+		return nil
+	}
+
+	client := GetClient(t)
+	client.AddRetryConsumer(exchange(), exchangeType(), queue, key, handler, RetryOptions{
+		MaxRetries:      5,
+		InitialBackoff:  100 * time.Millisecond,
+		MaxBackoff:      time.Second,
+		Multiplier:      2,
+		DeadLetterQueue: "test_retry_dlq",
+	})
+
+	if err := client.Publish(key, expected); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case successfulAttempt := <-coord:
+		if successfulAttempt != 3 {
+			t.Errorf("handler succeeded on attempt %d instead of 3", successfulAttempt)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("handler never succeeded")
+	}
+}
+
+func TestReplayDeadLetters(t *testing.T) {
+	if !shouldrun() {
+		return
+	}
+
+	queue := "test_retry_queue2"
+	key := "tests.retry.dlq"
+	dlq := "test_retry_dlq2"
+	expected := []byte("this always fails")
+
+	handler := func(_ context.Context, d amqp.Delivery) error {
+		return fmt.Errorf("always fails")
+	}
+
+	client := GetClient(t)
+	client.AddRetryConsumer(exchange(), exchangeType(), queue, key, handler, RetryOptions{
+		MaxRetries:      1,
+		InitialBackoff:  50 * time.Millisecond,
+		MaxBackoff:      100 * time.Millisecond,
+		Multiplier:      2,
+		DeadLetterQueue: dlq,
+	})
+
+	if err := client.Publish(key, expected); err != nil {
+		t.Fatal(err)
+	}
+
+	// Give the message time to exhaust its retries and land in the DLQ.
+	time.Sleep(2 * time.Second)
+
+	replayed, err := client.ReplayDeadLetters(dlq, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replayed != 1 {
+		t.Errorf("ReplayDeadLetters replayed %d messages instead of 1", replayed)
+	}
+
+	// The DLQ should be empty now that the only message in it was replayed.
+	replayed, err = client.ReplayDeadLetters(dlq, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replayed != 0 {
+		t.Errorf("ReplayDeadLetters replayed %d messages instead of 0", replayed)
+	}
+}